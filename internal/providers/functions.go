@@ -4,22 +4,37 @@
 package providers
 
 import (
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
-	"io"
+	"log"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/zclconf/go-cty/cty"
 	"github.com/zclconf/go-cty/cty/function"
 
 	"github.com/hashicorp/terraform/internal/addrs"
 	"github.com/hashicorp/terraform/internal/configs/configschema"
+	"github.com/hashicorp/terraform/internal/tfdiags"
 )
 
 // functionResultsCache is a global cache to verify the pure-ness of all
 // provider implemented functions.
 var functionResultsCache = newFunctionResults()
 
+// DefaultFunctionCallTimeout is the timeout applied to a single provider
+// function call when its FunctionDecl doesn't set Timeout explicitly.
+//
+// This package has no visibility into Terraform's CLI configuration or
+// environment variables, so whatever assembles a FunctionDecl from the CLI
+// layer (for example in response to a TF_PROVIDER_FUNCTION_TIMEOUT
+// environment variable) is responsible for overriding this or setting
+// Timeout per-declaration instead.
+var DefaultFunctionCallTimeout = 30 * time.Second
+
 type FunctionDecl struct {
 	Parameters        []FunctionParam
 	VariadicParameter *FunctionParam
@@ -27,6 +42,35 @@ type FunctionDecl struct {
 
 	Description     string
 	DescriptionKind configschema.StringKind
+
+	// Memoizable indicates that this function is pure with respect to its
+	// arguments, so BuildFunction may skip calling the provider again for
+	// arguments it has already seen during this run and instead return the
+	// previously-obtained result directly from functionMemoCache.
+	//
+	// The zero value, false, disables memoization, consistent with the
+	// other opt-in fields on FunctionParam below. Whatever constructs a
+	// FunctionDecl from a provider's schema is responsible for setting this
+	// to true for functions the provider has explicitly marked as pure.
+	Memoizable bool
+
+	// Timeout bounds how long a single call to this function is allowed to
+	// run before BuildFunctionWithContext gives up waiting and returns a
+	// diagnostic naming the function and provider, so a slow or hung
+	// provider function can't stall an entire graph walk indefinitely from
+	// this package's point of view.
+	//
+	// Zero means use DefaultFunctionCallTimeout.
+	//
+	// NOTE: this only bounds how long BuildFunctionWithContext itself waits;
+	// it doesn't make a hung provider subprocess actually stop running.
+	// That requires the gRPC transports in internal/plugin and
+	// internal/plugin6 to watch CallFunctionRequest.Context and interrupt
+	// the outgoing call, which doesn't exist in this checkout (neither
+	// package is present here). Until that's done, Timeout only protects a
+	// caller that's willing to give up on the response; the real provider
+	// process can still be left running and consuming resources.
+	Timeout time.Duration
 }
 
 type FunctionParam struct {
@@ -40,6 +84,31 @@ type FunctionParam struct {
 	DescriptionKind configschema.StringKind
 }
 
+// FunctionDiagnosticsSink receives diagnostics produced by a provider
+// function call that a cty function has no way to return on its own, since
+// a cty function's Impl can only report failure as a single Go error.
+//
+// Currently the only diagnostics forwarded this way are warnings: errors
+// still flow back through the returned error as before, and callers that
+// don't care about warnings can pass a nil sink to BuildFunction.
+//
+// NOTE: nothing in this checkout constructs a non-nil FunctionDiagnosticsSink
+// yet. lang.Scope, which is where a real sink would be wired in so that
+// `terraform plan` can render these as "Warning: ..." output, doesn't exist
+// in this checkout either, so today every real call site still passes nil
+// and warnings are silently discarded exactly as before this type existed.
+// Wiring lang.Scope to provide one (and to attach a source range to what it
+// receives, using the HCL expression it's evaluating) is follow-up work.
+type FunctionDiagnosticsSink interface {
+	// FunctionWarning is called once for each warning-severity diagnostic
+	// returned alongside a function call, whether or not that call also
+	// produced an error. It is the caller's responsibility to attach a
+	// source range to the diagnostic if one is appropriate: BuildFunction
+	// has no visibility into the HCL expression that triggered the call,
+	// only lang.Scope does.
+	FunctionWarning(providerAddr addrs.Provider, function string, diag tfdiags.Diagnostic)
+}
+
 // BuildFunction takes a factory function which will return an unconfigured
 // instance of the provider this declaration belongs to and returns a
 // cty function that is ready to be called against that provider.
@@ -57,7 +126,27 @@ type FunctionParam struct {
 // function that either retrieves already-running plugins or memoizes the
 // plugins it returns so that many calls to functions in the same provider
 // will not incur a repeated startup cost.
-func (d FunctionDecl) BuildFunction(providerAddr addrs.Provider, name string, factory func() (Interface, error)) function.Function {
+//
+// sink, if non-nil, receives any warning diagnostics CallFunction returns,
+// whether or not the same response also carries an error. Pass nil if the
+// caller has no way to surface warnings, in which case they are silently
+// discarded as before.
+//
+// BuildFunction calls the function with context.Background, so it never
+// times out or otherwise cancels early. Callers that can offer a more
+// specific context, such as lang.Scope during expression evaluation,
+// should call BuildFunctionWithContext instead.
+func (d FunctionDecl) BuildFunction(providerAddr addrs.Provider, name string, sink FunctionDiagnosticsSink, factory func() (Interface, error)) function.Function {
+	return d.BuildFunctionWithContext(context.Background(), providerAddr, name, sink, factory)
+}
+
+// BuildFunctionWithContext is like BuildFunction, but threads ctx through to
+// each call to the provider so that the call can be cancelled, and so that
+// it is bounded by d.Timeout (or DefaultFunctionCallTimeout, if Timeout is
+// zero). If the call doesn't complete in time, the returned function
+// reports an error naming the function and provider rather than hanging
+// the caller indefinitely.
+func (d FunctionDecl) BuildFunctionWithContext(ctx context.Context, providerAddr addrs.Provider, name string, sink FunctionDiagnosticsSink, factory func() (Interface, error)) function.Function {
 
 	var params []function.Parameter
 	var varParam *function.Parameter
@@ -103,20 +192,73 @@ func (d FunctionDecl) BuildFunction(providerAddr addrs.Provider, name string, fa
 				}
 			}
 
+			var memoK memoKey
+			if d.Memoizable {
+				memoK = newMemoKey(providerAddr, name, args)
+				if result, hit, firstServe := getFunctionMemoCache().get(memoK); hit {
+					if firstServe {
+						log.Printf("[DEBUG] providers: serving memoized result for %s::%s(...)", providerAddr, name)
+					}
+					return result, nil
+				}
+			}
+
 			provider, err := factory()
 			if err != nil {
 				return cty.UnknownVal(retType), fmt.Errorf("failed to launch provider plugin: %s", err)
 			}
 
-			resp := provider.CallFunction(CallFunctionRequest{
+			if EnableSchemaFingerprinting {
+				if err := schemaFingerprintsCache.check(providerAddr, provider, provider.GetProviderSchema); err != nil {
+					return cty.UnknownVal(retType), err
+				}
+			}
+
+			timeout := d.Timeout
+			if timeout <= 0 {
+				timeout = DefaultFunctionCallTimeout
+			}
+			callCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			req := CallFunctionRequest{
+				Context:      callCtx,
 				FunctionName: name,
 				Arguments:    args,
-			})
-			// NOTE: We don't actually have any way to surface warnings
-			// from the function here, because functions just return normal
-			// Go errors rather than diagnostics.
+			}
+			// The call runs in its own goroutine so that we can stop waiting
+			// for it once callCtx is done, even if the provider ignores its
+			// context. The channel is buffered so a late response is dropped.
+			respCh := make(chan CallFunctionResponse, 1)
+			go func() { respCh <- provider.CallFunction(req) }()
+			var resp CallFunctionResponse
+			select {
+			case resp = <-respCh:
+			case <-callCtx.Done():
+				return cty.UnknownVal(retType), fmt.Errorf("provider function %s::%s did not complete within %s: %w", providerAddr, name, timeout, callCtx.Err())
+			}
+			// Forward any warnings before checking for errors: a provider
+			// can legitimately return a warning alongside an error in the
+			// same response, and the warning is still worth surfacing even
+			// though the call as a whole failed.
+			if sink != nil {
+				for _, diag := range resp.Diagnostics {
+					if diag.Severity() == tfdiags.Warning {
+						sink.FunctionWarning(providerAddr, name, diag)
+					}
+				}
+			}
+
 			if resp.Diagnostics.HasErrors() {
-				return cty.UnknownVal(retType), resp.Diagnostics.Err()
+				err := resp.Diagnostics.Err()
+				if errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+					// Preserve whatever the provider actually reported
+					// rather than replacing it outright: the deadline
+					// expiring doesn't rule out the provider having also
+					// returned a genuine error of its own.
+					return cty.UnknownVal(retType), fmt.Errorf("provider function %s::%s did not complete within %s: %w", providerAddr, name, timeout, err)
+				}
+				return cty.UnknownVal(retType), err
 			}
 
 			if resp.Result == cty.NilVal {
@@ -128,6 +270,10 @@ func (d FunctionDecl) BuildFunction(providerAddr addrs.Provider, name string, fa
 				return cty.UnknownVal(retType), err
 			}
 
+			if d.Memoizable {
+				getFunctionMemoCache().put(memoK, resp.Result)
+			}
+
 			return resp.Result, nil
 		},
 	})
@@ -155,6 +301,13 @@ func (p *FunctionParam) ctyParameter() function.Parameter {
 }
 
 type priorResult struct {
+	// provider and name identify which provider function produced this
+	// result, so that a persisted result can be reported back to the user
+	// in a useful diagnostic and so that Marshal can reconstruct a
+	// FunctionResultHash for it.
+	provider addrs.Provider
+	name     string
+
 	hash [sha256.Size]byte
 	// when the result was from a current run, we keep a record of the result
 	// value to aid in debugging. Results stored in the plan will only have the
@@ -163,6 +316,21 @@ type priorResult struct {
 	value cty.Value
 }
 
+// FunctionResultHash is the persistable form of a single provider function
+// result, as recorded during plan so that a later apply of the same plan can
+// confirm that the provider returned the same result both times.
+//
+// This intentionally carries only hashes of the call's arguments and result,
+// rather than the cty.Value themselves, so that persisting these alongside a
+// plan doesn't risk bloating it with what could be arbitrarily large values.
+type FunctionResultHash struct {
+	Provider addrs.Provider
+	Function string
+
+	ArgsHash   [sha256.Size]byte
+	ResultHash [sha256.Size]byte
+}
+
 type functionResults struct {
 	mu sync.Mutex
 	// results stores the prior result from a provider function call, keyed by
@@ -179,58 +347,123 @@ func newFunctionResults() *functionResults {
 // checkPrior compares the function call against any cached results, and
 // returns an error if the result does not match a prior call.
 func (f *functionResults) checkPrior(provider addrs.Provider, name string, args []cty.Value, result cty.Value) error {
-	argSum := sha256.New()
-
-	io.WriteString(argSum, provider.String())
-	io.WriteString(argSum, "|"+name)
-
-	for _, arg := range args {
-		// cty.Values have a Hash method, but it is not collision resistant. We
-		// are going to rely on the GoString formatting instead, which gives
-		// detailed results for all values.
-		io.WriteString(argSum, "|"+arg.GoString())
-	}
+	// We hash with unmarkSensitive: true, since we need to be able to walk
+	// into marked values at all, but the mark set is still folded into the
+	// hash so a value that becomes (or stops being) sensitive between plan
+	// and apply is still treated as inconsistent.
+	argHash := HashCallArguments(provider, name, args, true)
+	resHash := HashCallResult(result, true)
 
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	argHash := [sha256.Size]byte(argSum.Sum(nil))
-	resHash := sha256.Sum256([]byte(result.GoString()))
-
 	res, ok := f.results[argHash]
 	if !ok {
 		f.results[argHash] = priorResult{
-			hash:  resHash,
-			value: result,
+			provider: provider,
+			name:     name,
+			hash:     resHash,
+			value:    result,
 		}
 		return nil
 	}
 
-	// FIXME: We don't have marks at this point, so we can't skip sensitive
-	// values. We may not be able to provide the result value for debugging.
 	if resHash != res.hash {
 		// The hcl package will add the necessary context around the error in
 		// the diagnostic, but we add the differing results when we can.
 		// TODO: maybe we should add a call to action, since this is a bug in
 		//       the provider.
+		call := fmt.Sprintf("%s::%s(%s)", provider, name, formatArgsForError(args))
 		if res.value != cty.NilVal {
-			return fmt.Errorf("Provider function returned an inconsistent result,\nwas: %#v,\nnow: %#v", res.value, result)
-
+			return fmt.Errorf("Provider function %s returned an inconsistent result,\nwas: %#v,\nnow: %#v", call, res.value, result)
 		}
-		return fmt.Errorf("Provider function returned an inconsistent result.")
+		return fmt.Errorf("Provider function %s returned a result inconsistent with its result during plan.", call)
 	}
 
 	return nil
 }
 
+// formatArgsForError renders a best-effort, human-readable argument list for
+// use in an inconsistent-result diagnostic. It intentionally reuses the same
+// GoString-based rendering as the result mismatch message above, rather than
+// anything collision-resistant, since this is for display only.
+func formatArgsForError(args []cty.Value) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = arg.GoString()
+	}
+	return strings.Join(parts, ", ")
+}
+
 // add inserts a new key-value pair to the functionResults map. This is used to
-// preload stored values before any Verify calls are made.
-func (f *functionResults) add(argHash, resHash [sha256.Size]byte) {
+// preload stored values before any checkPrior calls are made.
+func (f *functionResults) add(provider addrs.Provider, name string, argHash, resHash [sha256.Size]byte) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
 	if _, ok := f.results[argHash]; ok {
 		return
 	}
-	f.results[argHash] = priorResult{hash: resHash}
+	f.results[argHash] = priorResult{
+		provider: provider,
+		name:     name,
+		hash:     resHash,
+	}
+}
+
+// Marshal produces a persistable snapshot of every provider function result
+// observed so far in the current run, for a caller such as the plans package
+// to save alongside a plan.
+func (f *functionResults) Marshal() []FunctionResultHash {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ret := make([]FunctionResultHash, 0, len(f.results))
+	for argHash, res := range f.results {
+		ret = append(ret, FunctionResultHash{
+			Provider:   res.provider,
+			Function:   res.name,
+			ArgsHash:   argHash,
+			ResultHash: res.hash,
+		})
+	}
+	return ret
+}
+
+// Unmarshal preloads the cache from hashes recorded in a prior plan, so that
+// checkPrior can detect during apply that a provider function returned a
+// different result than it did during plan.
+func (f *functionResults) Unmarshal(hashes []FunctionResultHash) {
+	for _, h := range hashes {
+		f.add(h.Provider, h.Function, h.ArgsHash, h.ResultHash)
+	}
+}
+
+// FunctionResults returns a persistable snapshot of every provider function
+// result observed so far in the current run, for a caller such as the
+// plans/planfile package to save alongside a plan so that
+// ImportFunctionResults can preload them again for the apply phase of that
+// same plan.
+//
+// NOTE: as of this commit, nothing actually calls FunctionResults or
+// ImportFunctionResults: the plans/planfile package these are meant to be
+// wired into doesn't exist in this checkout, so the save/reload contract
+// below is exercised directly by this package's own tests
+// (TestFunctionResultsPackageLevelRoundTrip) rather than by a real plan
+// file round trip. Wiring plans/planfile to call these at the appropriate
+// points is follow-up work, not something this change can complete without
+// that package present.
+func FunctionResults() []FunctionResultHash {
+	return functionResultsCache.Marshal()
+}
+
+// ImportFunctionResults preloads the process-wide function results cache
+// from hashes recorded in a prior plan. The plans/planfile package is meant
+// to call this while reading a saved plan back in, before any provider
+// functions are evaluated during apply, so that a provider returning a
+// result inconsistent with its plan-time result produces a diagnostic
+// instead of silently being applied. See the NOTE on FunctionResults: that
+// wiring doesn't exist yet in this checkout.
+func ImportFunctionResults(hashes []FunctionResultHash) {
+	functionResultsCache.Unmarshal(hashes)
 }
@@ -0,0 +1,189 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package providers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/tfdiags"
+)
+
+// fakeProvider is a minimal Interface implementation for exercising
+// BuildFunction/BuildFunctionWithContext's wiring to memoization, schema
+// fingerprinting, the diagnostics sink, and call timeouts together, rather
+// than testing each of those in isolation. It embeds Interface so it only
+// needs to implement the methods these tests actually call; anything else
+// would panic on a nil embedded value, which would itself mean a test bug.
+type fakeProvider struct {
+	Interface
+
+	schema   GetProviderSchemaResponse
+	callFunc func(CallFunctionRequest) CallFunctionResponse
+}
+
+func (p *fakeProvider) GetProviderSchema() GetProviderSchemaResponse {
+	return p.schema
+}
+
+func (p *fakeProvider) CallFunction(req CallFunctionRequest) CallFunctionResponse {
+	return p.callFunc(req)
+}
+
+func greetDecl(memoizable bool) FunctionDecl {
+	return FunctionDecl{
+		Parameters: []FunctionParam{{Name: "name", Type: cty.String}},
+		ReturnType: cty.String,
+		Memoizable: memoizable,
+	}
+}
+
+func TestBuildFunctionMemoizesThroughRealCall(t *testing.T) {
+	provider := addrs.NewDefaultProvider("test-memoize")
+	calls := 0
+	fake := &fakeProvider{
+		schema: GetProviderSchemaResponse{Functions: map[string]FunctionDecl{"greet": greetDecl(true)}},
+		callFunc: func(req CallFunctionRequest) CallFunctionResponse {
+			calls++
+			return CallFunctionResponse{Result: cty.StringVal("hello, " + req.Arguments[0].AsString())}
+		},
+	}
+
+	fn := greetDecl(true).BuildFunction(provider, "greet", nil, func() (Interface, error) { return fake, nil })
+
+	for i := 0; i < 3; i++ {
+		result, err := fn.Call([]cty.Value{cty.StringVal("a")})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if result != cty.StringVal("hello, a") {
+			t.Errorf("wrong result: got %#v", result)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("provider's CallFunction was invoked %d times, want 1 (later calls should be served from the memo cache)", calls)
+	}
+}
+
+type fakeDiagnosticsSink struct {
+	warnings []tfdiags.Diagnostic
+}
+
+func (s *fakeDiagnosticsSink) FunctionWarning(providerAddr addrs.Provider, function string, diag tfdiags.Diagnostic) {
+	s.warnings = append(s.warnings, diag)
+}
+
+func TestBuildFunctionForwardsWarningsThroughRealCall(t *testing.T) {
+	provider := addrs.NewDefaultProvider("test-warnings")
+	fake := &fakeProvider{
+		schema: GetProviderSchemaResponse{Functions: map[string]FunctionDecl{"greet": greetDecl(false)}},
+		callFunc: func(req CallFunctionRequest) CallFunctionResponse {
+			var diags tfdiags.Diagnostics
+			diags = diags.Append(tfdiags.SimpleWarning("the provider has a warning"))
+			return CallFunctionResponse{Result: cty.StringVal("hi"), Diagnostics: diags}
+		},
+	}
+	sink := &fakeDiagnosticsSink{}
+
+	fn := greetDecl(false).BuildFunction(provider, "greet", sink, func() (Interface, error) { return fake, nil })
+	if _, err := fn.Call([]cty.Value{cty.StringVal("a")}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(sink.warnings) != 1 {
+		t.Fatalf("sink received %d warnings, want 1", len(sink.warnings))
+	}
+}
+
+func TestBuildFunctionForwardsWarningsAlongsideAnError(t *testing.T) {
+	provider := addrs.NewDefaultProvider("test-warnings-and-error")
+	fake := &fakeProvider{
+		schema: GetProviderSchemaResponse{Functions: map[string]FunctionDecl{"greet": greetDecl(false)}},
+		callFunc: func(req CallFunctionRequest) CallFunctionResponse {
+			var diags tfdiags.Diagnostics
+			diags = diags.Append(tfdiags.SimpleWarning("the provider has a warning"))
+			diags = diags.Append(errors.New("and also an error"))
+			return CallFunctionResponse{Diagnostics: diags}
+		},
+	}
+	sink := &fakeDiagnosticsSink{}
+
+	fn := greetDecl(false).BuildFunction(provider, "greet", sink, func() (Interface, error) { return fake, nil })
+	if _, err := fn.Call([]cty.Value{cty.StringVal("a")}); err == nil {
+		t.Fatal("expected an error, but got none")
+	}
+
+	// The warning must still reach the sink even though the same response
+	// also carried an error.
+	if len(sink.warnings) != 1 {
+		t.Fatalf("sink received %d warnings, want 1", len(sink.warnings))
+	}
+}
+
+func TestBuildFunctionDetectsSchemaDriftThroughRealCall(t *testing.T) {
+	provider := addrs.NewDefaultProvider("test-schema-drift")
+	callFunc := func(req CallFunctionRequest) CallFunctionResponse {
+		return CallFunctionResponse{Result: cty.StringVal("hi")}
+	}
+	decl := greetDecl(false)
+
+	fakeV1 := &fakeProvider{
+		schema:   GetProviderSchemaResponse{Functions: map[string]FunctionDecl{"greet": greetDecl(false)}},
+		callFunc: callFunc,
+	}
+	fn1 := decl.BuildFunction(provider, "greet", nil, func() (Interface, error) { return fakeV1, nil })
+	if _, err := fn1.Call([]cty.Value{cty.StringVal("a")}); err != nil {
+		t.Fatalf("unexpected error for the provider's first instantiation: %s", err)
+	}
+
+	// A distinct instance of the same provider, but with a changed
+	// function return type, must be rejected the next time it's called
+	// through BuildFunction rather than producing a confusing cty type
+	// error somewhere downstream.
+	fakeV2 := &fakeProvider{
+		schema: GetProviderSchemaResponse{Functions: map[string]FunctionDecl{
+			"greet": {Parameters: greetDecl(false).Parameters, ReturnType: cty.Number},
+		}},
+		callFunc: callFunc,
+	}
+	fn2 := decl.BuildFunction(provider, "greet", nil, func() (Interface, error) { return fakeV2, nil })
+	if _, err := fn2.Call([]cty.Value{cty.StringVal("a")}); err == nil {
+		t.Fatal("expected an error for a provider whose schema changed mid-run, but got none")
+	}
+}
+
+func TestBuildFunctionWithContextTimesOutOnRealCall(t *testing.T) {
+	provider := addrs.NewDefaultProvider("test-timeout")
+	fake := &fakeProvider{
+		schema: GetProviderSchemaResponse{Functions: map[string]FunctionDecl{"slow": greetDecl(false)}},
+		callFunc: func(req CallFunctionRequest) CallFunctionResponse {
+			// A well-behaved provider, like the real gRPC transports this
+			// is meant to pair with, gives up as soon as its context is
+			// done rather than waiting for its own work to finish.
+			<-req.Context.Done()
+			var diags tfdiags.Diagnostics
+			diags = diags.Append(req.Context.Err())
+			return CallFunctionResponse{Diagnostics: diags}
+		},
+	}
+
+	decl := greetDecl(false)
+	decl.Timeout = 10 * time.Millisecond
+	fn := decl.BuildFunctionWithContext(context.Background(), provider, "slow", nil, func() (Interface, error) { return fake, nil })
+
+	_, err := fn.Call([]cty.Value{cty.StringVal("a")})
+	if err == nil {
+		t.Fatal("expected a timeout error, but got none")
+	}
+	if !strings.Contains(err.Error(), "did not complete within") {
+		t.Errorf("error does not mention the timeout: %s", err)
+	}
+}
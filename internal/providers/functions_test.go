@@ -0,0 +1,123 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package providers
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+)
+
+func TestFunctionResultsMarshalUnmarshal(t *testing.T) {
+	provider := addrs.NewDefaultProvider("test")
+
+	orig := newFunctionResults()
+	err := orig.checkPrior(provider, "greet", []cty.Value{cty.StringVal("a")}, cty.StringVal("hello, a"))
+	if err != nil {
+		t.Fatalf("unexpected error recording first result: %s", err)
+	}
+
+	hashes := orig.Marshal()
+	if len(hashes) != 1 {
+		t.Fatalf("wrong number of marshaled results: got %d, want 1", len(hashes))
+	}
+	got := hashes[0]
+	if got.Provider != provider {
+		t.Errorf("wrong provider: got %s, want %s", got.Provider, provider)
+	}
+	if got.Function != "greet" {
+		t.Errorf("wrong function name: got %q, want %q", got.Function, "greet")
+	}
+
+	// A fresh cache, as would be constructed at the start of an apply,
+	// preloaded from the marshaled results recorded during plan.
+	reloaded := newFunctionResults()
+	reloaded.Unmarshal(hashes)
+
+	// Calling with the same arguments and result as during plan must not
+	// produce an error: the provider was consistent.
+	err = reloaded.checkPrior(provider, "greet", []cty.Value{cty.StringVal("a")}, cty.StringVal("hello, a"))
+	if err != nil {
+		t.Errorf("unexpected error for a consistent result: %s", err)
+	}
+
+	// Calling with the same arguments but a different result, as would
+	// happen if the provider changed its mind between plan and apply, must
+	// produce an error naming the inconsistent call.
+	reloaded2 := newFunctionResults()
+	reloaded2.Unmarshal(hashes)
+	err = reloaded2.checkPrior(provider, "greet", []cty.Value{cty.StringVal("a")}, cty.StringVal("hello, a (changed)"))
+	if err == nil {
+		t.Fatal("expected an error for an inconsistent result, but got none")
+	}
+}
+
+// TestFunctionResultsPackageLevelRoundTrip exercises FunctionResults and
+// ImportFunctionResults themselves, through a real BuildFunction call,
+// rather than the functionResults methods they wrap.
+//
+// Nothing in this checkout actually calls these two functions: the
+// plans/planfile package they're meant to be wired into (save the plan-time
+// results alongside the plan, then preload them before apply) doesn't exist
+// here. This test stands in for that missing round trip by simulating it
+// directly: snapshot after a plan-time call, swap in a fresh cache preloaded
+// from that snapshot the way a new apply process would be, and confirm
+// checkPrior still catches a provider that changes its answer in between.
+func TestFunctionResultsPackageLevelRoundTrip(t *testing.T) {
+	provider := addrs.NewDefaultProvider("test-plan-apply-roundtrip")
+	fake := &fakeProvider{
+		schema: GetProviderSchemaResponse{Functions: map[string]FunctionDecl{"greet": greetDecl(false)}},
+		callFunc: func(req CallFunctionRequest) CallFunctionResponse {
+			return CallFunctionResponse{Result: cty.StringVal("hello, " + req.Arguments[0].AsString())}
+		},
+	}
+	fn := greetDecl(false).BuildFunction(provider, "greet", nil, func() (Interface, error) { return fake, nil })
+
+	if _, err := fn.Call([]cty.Value{cty.StringVal("a")}); err != nil {
+		t.Fatalf("unexpected error during simulated plan-time call: %s", err)
+	}
+	saved := FunctionResults()
+
+	// Simulate apply starting in a fresh process: a blank cache preloaded
+	// from what was "saved" during plan.
+	orig := functionResultsCache
+	functionResultsCache = newFunctionResults()
+	t.Cleanup(func() { functionResultsCache = orig })
+	ImportFunctionResults(saved)
+
+	// The provider returning the same result for the same arguments during
+	// simulated apply must not be flagged as inconsistent.
+	if _, err := fn.Call([]cty.Value{cty.StringVal("a")}); err != nil {
+		t.Errorf("unexpected error for a consistent result during simulated apply: %s", err)
+	}
+
+	// A provider that changes its answer for the same arguments between
+	// plan and apply must be caught.
+	fake.callFunc = func(req CallFunctionRequest) CallFunctionResponse {
+		return CallFunctionResponse{Result: cty.StringVal("hello, a (changed)")}
+	}
+	if _, err := fn.Call([]cty.Value{cty.StringVal("a")}); err == nil {
+		t.Error("expected an error for a provider that changed its result between plan and apply, but got none")
+	}
+}
+
+func TestFunctionResultsUnmarshalDoesNotOverwrite(t *testing.T) {
+	provider := addrs.NewDefaultProvider("test")
+	var argHash, oldResHash, newResHash [sha256.Size]byte
+	argHash[0] = 1
+	oldResHash[0] = 2
+	newResHash[0] = 3
+
+	f := newFunctionResults()
+	f.add(provider, "greet", argHash, oldResHash)
+	f.add(provider, "greet", argHash, newResHash)
+
+	got := f.results[argHash]
+	if got.hash != oldResHash {
+		t.Errorf("add overwrote an existing entry: got %x, want %x", got.hash, oldResHash)
+	}
+}
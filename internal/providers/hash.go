@@ -0,0 +1,240 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package providers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+)
+
+// Tag bytes identifying the shape of the value that follows in the stream
+// produced by hashValue. These are part of the hash's on-disk contract: a
+// provider function's plan-time and apply-time calls must produce the same
+// tag stream for the same logical value, so these must never be reordered
+// or reused for a different meaning.
+const (
+	hashTagNull byte = iota
+	hashTagUnknown
+	hashTagBool
+	hashTagNumber
+	hashTagString
+	hashTagList
+	hashTagSet
+	hashTagTuple
+	hashTagMap
+	hashTagObject
+	hashTagCapsule
+	hashTagMarked
+)
+
+// HashCallArguments produces a canonical, collision-resistant hash of a
+// provider function call's arguments, suitable for detecting whether a
+// later call to the same function used the same arguments.
+//
+// Unlike hashing the GoString representation of the arguments, this walks
+// each value directly into the hash without ever materializing a full
+// pretty-printed copy of it, so it stays cheap even for very large values.
+//
+// If unmarkSensitive is true, marks are stripped from each value before its
+// content is hashed (since most cty.Value accessors panic on a marked
+// value), but the mark set itself is still folded into the hash so that two
+// otherwise-identical calls that differ only in which values are marked
+// still produce different hashes.
+func HashCallArguments(provider addrs.Provider, function string, args []cty.Value, unmarkSensitive bool) [sha256.Size]byte {
+	h := sha256.New()
+	writeString(h, provider.String())
+	writeString(h, function)
+	writeUvarint(h, uint64(len(args)))
+	for _, arg := range args {
+		hashValue(h, arg, unmarkSensitive)
+	}
+	return hashSum(h)
+}
+
+// HashCallResult produces a canonical, collision-resistant hash of a
+// provider function call's result, using the same value encoding as
+// HashCallArguments.
+func HashCallResult(result cty.Value, unmarkSensitive bool) [sha256.Size]byte {
+	h := sha256.New()
+	hashValue(h, result, unmarkSensitive)
+	return hashSum(h)
+}
+
+// hashValue writes a canonical encoding of v into h: a type tag byte
+// followed by a length-prefixed encoding of the value's content, recursing
+// into collections and objects in a deterministic order (declared order for
+// lists/tuples, sorted-key order for maps/objects, and the order of each
+// element's own hash for sets).
+func hashValue(h hash.Hash, v cty.Value, unmarkSensitive bool) {
+	if v.IsMarked() {
+		if !unmarkSensitive {
+			// Most cty.Value methods panic on a marked value, so without
+			// permission to unmark we have no way to look inside it. Callers
+			// that need exact fidelity for marked values should pass
+			// unmarkSensitive: true.
+			h.Write([]byte{hashTagMarked})
+			return
+		}
+		unmarked, marks := v.Unmark()
+		h.Write([]byte{hashTagMarked})
+		writeMarks(h, marks)
+		hashValue(h, unmarked, unmarkSensitive)
+		return
+	}
+
+	switch {
+	case v.IsNull():
+		h.Write([]byte{hashTagNull})
+		writeString(h, v.Type().GoString())
+		return
+	case !v.IsKnown():
+		h.Write([]byte{hashTagUnknown})
+		writeString(h, v.Type().GoString())
+		return
+	}
+
+	ty := v.Type()
+	switch {
+	case ty == cty.Bool:
+		h.Write([]byte{hashTagBool})
+		if v.True() {
+			h.Write([]byte{1})
+		} else {
+			h.Write([]byte{0})
+		}
+
+	case ty == cty.Number:
+		h.Write([]byte{hashTagNumber})
+		// Text('g', -1) on the underlying big.Float gives the shortest
+		// decimal representation that round-trips exactly, which is both
+		// canonical and collision-resistant for our purposes.
+		writeString(h, v.AsBigFloat().Text('g', -1))
+
+	case ty == cty.String:
+		h.Write([]byte{hashTagString})
+		writeString(h, v.AsString())
+
+	case ty.IsListType() || ty.IsTupleType():
+		tag := hashTagList
+		if ty.IsTupleType() {
+			tag = hashTagTuple
+		}
+		h.Write([]byte{tag})
+		writeUvarint(h, uint64(v.LengthInt()))
+		for it := v.ElementIterator(); it.Next(); {
+			_, ev := it.Element()
+			hashValue(h, ev, unmarkSensitive)
+		}
+
+	case ty.IsSetType():
+		h.Write([]byte{hashTagSet})
+		elems := make([]cty.Value, 0, v.LengthInt())
+		for it := v.ElementIterator(); it.Next(); {
+			_, ev := it.Element()
+			elems = append(elems, ev)
+		}
+		// cty sets have no inherent iteration order, so we derive one from
+		// each element's own hash to keep the overall result deterministic.
+		sortBySubHash(elems, unmarkSensitive)
+		writeUvarint(h, uint64(len(elems)))
+		for _, ev := range elems {
+			hashValue(h, ev, unmarkSensitive)
+		}
+
+	case ty.IsMapType() || ty.IsObjectType():
+		tag := hashTagMap
+		if ty.IsObjectType() {
+			tag = hashTagObject
+		}
+		h.Write([]byte{tag})
+		keys := make([]string, 0, v.LengthInt())
+		vals := make(map[string]cty.Value, v.LengthInt())
+		for it := v.ElementIterator(); it.Next(); {
+			kv, ev := it.Element()
+			k := kv.AsString()
+			keys = append(keys, k)
+			vals[k] = ev
+		}
+		sort.Strings(keys)
+		writeUvarint(h, uint64(len(keys)))
+		for _, k := range keys {
+			writeString(h, k)
+			hashValue(h, vals[k], unmarkSensitive)
+		}
+
+	case ty.IsCapsuleType():
+		h.Write([]byte{hashTagCapsule})
+		writeString(h, ty.FriendlyName())
+		// Capsule-typed values have no general notion of deep equality or
+		// serialization, so we fall back to their Go representation. This
+		// is rare in practice: provider functions don't return capsule
+		// values over the plugin protocol today.
+		writeString(h, fmt.Sprintf("%#v", v.EncapsulatedValue()))
+
+	default:
+		// Should be unreachable for any value a provider function could
+		// actually produce, but we fall back to something deterministic
+		// rather than panicking.
+		h.Write([]byte{hashTagCapsule})
+		writeString(h, ty.FriendlyName())
+		writeString(h, v.GoString())
+	}
+}
+
+// writeMarks writes a deterministic encoding of a value's mark set, sorted
+// by the marks' own Go representation since marks are arbitrary comparable
+// values (often, but not always, a single well-known sentinel like
+// marks.Sensitive).
+func writeMarks(h hash.Hash, marks cty.ValueMarks) {
+	reprs := make([]string, 0, len(marks))
+	for m := range marks {
+		reprs = append(reprs, fmt.Sprintf("%#v", m))
+	}
+	sort.Strings(reprs)
+	writeUvarint(h, uint64(len(reprs)))
+	for _, r := range reprs {
+		writeString(h, r)
+	}
+}
+
+// sortBySubHash reorders elems in place into a canonical order derived from
+// each element's own hash, so that set values (which have no inherent
+// element order) still hash the same way regardless of iteration order.
+func sortBySubHash(elems []cty.Value, unmarkSensitive bool) {
+	keys := make([][sha256.Size]byte, len(elems))
+	for i, v := range elems {
+		sub := sha256.New()
+		hashValue(sub, v, unmarkSensitive)
+		keys[i] = hashSum(sub)
+	}
+	sort.Slice(elems, func(i, j int) bool {
+		return bytes.Compare(keys[i][:], keys[j][:]) < 0
+	})
+}
+
+func writeString(w io.Writer, s string) {
+	writeUvarint(w, uint64(len(s)))
+	io.WriteString(w, s)
+}
+
+func writeUvarint(w io.Writer, n uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	l := binary.PutUvarint(buf[:], n)
+	w.Write(buf[:l])
+}
+
+func hashSum(h hash.Hash) [sha256.Size]byte {
+	var ret [sha256.Size]byte
+	copy(ret[:], h.Sum(nil))
+	return ret
+}
@@ -0,0 +1,84 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package providers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+)
+
+type testCapsuleContent struct{ V int }
+
+func TestHashCallArguments(t *testing.T) {
+	provider := addrs.NewDefaultProvider("test")
+
+	tests := map[string]struct {
+		a, b  cty.Value
+		equal bool
+	}{
+		"identical strings": {
+			cty.StringVal("a"), cty.StringVal("a"), true,
+		},
+		"different strings": {
+			cty.StringVal("a"), cty.StringVal("b"), false,
+		},
+		"null vs known of same type": {
+			cty.NullVal(cty.String), cty.StringVal(""), false,
+		},
+		"null of different types": {
+			cty.NullVal(cty.String), cty.NullVal(cty.Number), false,
+		},
+		"unknown vs known": {
+			cty.UnknownVal(cty.String), cty.StringVal("a"), false,
+		},
+		"dynamic unknown equal to itself": {
+			cty.DynamicVal, cty.DynamicVal, true,
+		},
+		"object key order does not matter": {
+			cty.ObjectVal(map[string]cty.Value{"a": cty.True, "b": cty.False}),
+			cty.ObjectVal(map[string]cty.Value{"b": cty.False, "a": cty.True}),
+			true,
+		},
+		"set element order does not matter": {
+			cty.SetVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}),
+			cty.SetVal([]cty.Value{cty.StringVal("b"), cty.StringVal("a")}),
+			true,
+		},
+		"list element order matters": {
+			cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}),
+			cty.ListVal([]cty.Value{cty.StringVal("b"), cty.StringVal("a")}),
+			false,
+		},
+		"marked value differs from unmarked": {
+			cty.StringVal("a").Mark("sensitive"), cty.StringVal("a"), false,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			gotA := HashCallArguments(provider, "f", []cty.Value{test.a}, true)
+			gotB := HashCallArguments(provider, "f", []cty.Value{test.b}, true)
+			if (gotA == gotB) != test.equal {
+				t.Errorf("wrong result: got equal=%v, want equal=%v", gotA == gotB, test.equal)
+			}
+		})
+	}
+}
+
+func TestHashCallResultCapsule(t *testing.T) {
+	capsuleType := cty.Capsule("test", reflect.TypeOf(testCapsuleContent{}))
+	a := cty.CapsuleVal(capsuleType, &testCapsuleContent{V: 1})
+	b := cty.CapsuleVal(capsuleType, &testCapsuleContent{V: 2})
+
+	if HashCallResult(a, true) == HashCallResult(b, true) {
+		t.Errorf("expected different capsule contents to hash differently")
+	}
+	if HashCallResult(a, true) != HashCallResult(a, true) {
+		t.Errorf("expected hashing the same capsule value twice to be stable")
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package providers
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+)
+
+// defaultFunctionMemoBudgetBytes is FunctionMemoBudgetBytes' default value.
+// This is deliberately generous: the cache exists to avoid repeating
+// expensive calls (network requests, shelling out, etc) within a single
+// run, not to hold onto results indefinitely.
+const defaultFunctionMemoBudgetBytes = 64 * 1024 * 1024 // 64MiB
+
+// FunctionMemoBudgetBytes bounds the total size of provider function
+// results functionMemoCache will hold onto at once.
+//
+// This package has no visibility into Terraform's CLI configuration or
+// environment variables, so whatever assembles FunctionDecls from the CLI
+// layer is responsible for overriding this before any provider function is
+// evaluated, the same way it's responsible for DefaultFunctionCallTimeout
+// and EnableSchemaFingerprinting.
+var FunctionMemoBudgetBytes = defaultFunctionMemoBudgetBytes
+
+// functionMemoCache is a global, process-wide memoization cache for
+// Memoizable provider functions, so that a pure function called repeatedly
+// with the same arguments during a single run only actually invokes the
+// provider once.
+//
+// It's built lazily, on first use, rather than from a package-level
+// initializer, so that an overridden FunctionMemoBudgetBytes is honored as
+// long as it's set before the first provider function call, matching when
+// DefaultFunctionCallTimeout and EnableSchemaFingerprinting are read.
+var (
+	functionMemoCacheOnce sync.Once
+	functionMemoCacheVal  *functionMemo
+)
+
+func getFunctionMemoCache() *functionMemo {
+	functionMemoCacheOnce.Do(func() {
+		functionMemoCacheVal = newFunctionMemo(FunctionMemoBudgetBytes)
+	})
+	return functionMemoCacheVal
+}
+
+// memoKey identifies a single memoizable function call by the canonical
+// hash of its arguments.
+type memoKey struct {
+	provider addrs.Provider
+	function string
+	argHash  [sha256.Size]byte
+}
+
+// newMemoKey computes the memoKey for a call to the named function on the
+// given provider with the given arguments.
+func newMemoKey(provider addrs.Provider, function string, args []cty.Value) memoKey {
+	return memoKey{
+		provider: provider,
+		function: function,
+		// We hash with unmarkSensitive: true, the same as checkPrior does:
+		// without unmarking, every marked argument would hash identically
+		// and two calls with different sensitive arguments would share a
+		// memoized result.
+		argHash: HashCallArguments(provider, function, args, true),
+	}
+}
+
+type memoEntry struct {
+	key   memoKey
+	value cty.Value
+	size  int
+	// served is set once this entry has been returned from get at least
+	// once, so the caller can tell whether a given hit is the first time
+	// the memoized value has actually saved a call to the provider.
+	served bool
+}
+
+// functionMemo is a byte-budgeted LRU cache of provider function results,
+// so that memoizing large results (such as a decoded JSON blob) can't grow
+// the cache without bound.
+type functionMemo struct {
+	mu sync.Mutex
+
+	budget int
+	used   int
+
+	// order tracks entries from most- to least-recently-used; lookup maps
+	// a key to its node in order so both get and put are O(1).
+	order  *list.List
+	lookup map[memoKey]*list.Element
+}
+
+func newFunctionMemo(budgetBytes int) *functionMemo {
+	return &functionMemo{
+		budget: budgetBytes,
+		order:  list.New(),
+		lookup: make(map[memoKey]*list.Element),
+	}
+}
+
+// get looks up key in the cache. The second return value reports whether
+// the entry was found at all; the third reports whether this is the first
+// time that entry has been served from the cache, for callers that want to
+// log the first time a memoized result actually saves a call to the
+// provider without logging on every subsequent hit.
+func (m *functionMemo) get(key memoKey) (value cty.Value, hit bool, firstServe bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.lookup[key]
+	if !ok {
+		return cty.NilVal, false, false
+	}
+	m.order.MoveToFront(el)
+
+	entry := el.Value.(*memoEntry)
+	firstServe = !entry.served
+	entry.served = true
+	return entry.value, true, firstServe
+}
+
+func (m *functionMemo) put(key memoKey, value cty.Value) {
+	size := approxValueSize(value)
+	if size > m.budget {
+		// This single result would never fit even in an empty cache, so
+		// there's no point remembering it.
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.lookup[key]; ok {
+		entry := el.Value.(*memoEntry)
+		m.used += size - entry.size
+		entry.value = value
+		entry.size = size
+		m.order.MoveToFront(el)
+	} else {
+		el := m.order.PushFront(&memoEntry{key: key, value: value, size: size})
+		m.lookup[key] = el
+		m.used += size
+	}
+
+	for m.used > m.budget {
+		back := m.order.Back()
+		if back == nil {
+			break
+		}
+		entry := back.Value.(*memoEntry)
+		m.order.Remove(back)
+		delete(m.lookup, entry.key)
+		m.used -= entry.size
+	}
+}
+
+// approxValueSize estimates the in-memory footprint of a cty.Value well
+// enough to bound the memo cache's total size. It doesn't need to be exact,
+// just roughly proportional to the value's real size.
+//
+// This walks the same canonical encoding hashValue uses and counts the
+// bytes that would be written, rather than materializing a full
+// pretty-printed copy of v via GoString: the values this cache exists to
+// protect memory against (large decoded JSON blobs, etc) are exactly the
+// ones a full stringify would be most expensive for.
+func approxValueSize(v cty.Value) int {
+	var c byteCounter
+	hashValue(&c, v, true)
+	return c.n
+}
+
+// byteCounter is a hash.Hash that discards everything written to it and
+// only counts the total number of bytes, so hashValue's traversal can be
+// reused purely to size a value without paying for an actual hash.
+type byteCounter struct{ n int }
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += len(p)
+	return len(p), nil
+}
+func (c *byteCounter) Sum(b []byte) []byte { return b }
+func (c *byteCounter) Reset()              { c.n = 0 }
+func (c *byteCounter) Size() int           { return 0 }
+func (c *byteCounter) BlockSize() int      { return 1 }
@@ -0,0 +1,89 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package providers
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+)
+
+func TestFunctionMemoGetPut(t *testing.T) {
+	provider := addrs.NewDefaultProvider("test")
+	m := newFunctionMemo(1024)
+
+	key := newMemoKey(provider, "greet", []cty.Value{cty.StringVal("a")})
+	if _, hit, _ := m.get(key); hit {
+		t.Fatal("unexpected hit before any put")
+	}
+
+	m.put(key, cty.StringVal("hello, a"))
+
+	value, hit, first := m.get(key)
+	if !hit {
+		t.Fatal("expected a hit after put")
+	}
+	if !first {
+		t.Error("expected the first get after put to report firstServe")
+	}
+	if value != cty.StringVal("hello, a") {
+		t.Errorf("wrong value: got %#v", value)
+	}
+
+	if _, hit, first := m.get(key); !hit || first {
+		t.Errorf("expected a repeat hit with firstServe=false, got hit=%v first=%v", hit, first)
+	}
+}
+
+func TestFunctionMemoEvictsOverBudget(t *testing.T) {
+	provider := addrs.NewDefaultProvider("test")
+	// A tiny budget, just enough for one short string's worth of entries.
+	m := newFunctionMemo(approxValueSize(cty.StringVal("aaaaaaaaaa")))
+
+	keyA := newMemoKey(provider, "greet", []cty.Value{cty.StringVal("a")})
+	keyB := newMemoKey(provider, "greet", []cty.Value{cty.StringVal("b")})
+
+	m.put(keyA, cty.StringVal("aaaaaaaaaa"))
+	m.put(keyB, cty.StringVal("bbbbbbbbbb"))
+
+	if _, hit, _ := m.get(keyA); hit {
+		t.Error("expected the least-recently-used entry to have been evicted")
+	}
+	if _, hit, _ := m.get(keyB); !hit {
+		t.Error("expected the most recently inserted entry to still be cached")
+	}
+}
+
+// TestGetFunctionMemoCacheHonorsBudgetOverride confirms that overriding
+// FunctionMemoBudgetBytes before the cache is first used actually takes
+// effect, since the cache is built lazily precisely so that this works.
+func TestGetFunctionMemoCacheHonorsBudgetOverride(t *testing.T) {
+	origBudget := FunctionMemoBudgetBytes
+	origOnce, origVal := functionMemoCacheOnce, functionMemoCacheVal
+	t.Cleanup(func() {
+		FunctionMemoBudgetBytes = origBudget
+		functionMemoCacheOnce = origOnce
+		functionMemoCacheVal = origVal
+	})
+
+	FunctionMemoBudgetBytes = approxValueSize(cty.StringVal("aaaaaaaaaa"))
+	functionMemoCacheOnce = sync.Once{}
+
+	provider := addrs.NewDefaultProvider("test")
+	keyA := newMemoKey(provider, "greet", []cty.Value{cty.StringVal("a")})
+	keyB := newMemoKey(provider, "greet", []cty.Value{cty.StringVal("b")})
+
+	getFunctionMemoCache().put(keyA, cty.StringVal("aaaaaaaaaa"))
+	getFunctionMemoCache().put(keyB, cty.StringVal("bbbbbbbbbb"))
+
+	if _, hit, _ := getFunctionMemoCache().get(keyA); hit {
+		t.Error("expected the least-recently-used entry to have been evicted under the overridden budget")
+	}
+	if _, hit, _ := getFunctionMemoCache().get(keyB); !hit {
+		t.Error("expected the most recently inserted entry to still be cached")
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package providers
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/configs/configschema"
+)
+
+// EnableSchemaFingerprinting controls whether BuildFunction's factory path
+// fingerprints each provider's schema and rejects a run in which that
+// schema changes between two instantiations of the same provider.
+//
+// This defaults to on, matching the equivalent always-on check this package
+// already does for function call results in checkPrior; set it to false
+// only if fingerprinting becomes a measurable bottleneck for a provider
+// with an unusually large schema.
+var EnableSchemaFingerprinting = true
+
+// schemaFingerprintsCache is a global, process-wide registry of the first
+// schema fingerprint observed for each provider, so that every later
+// instantiation of that provider within the same run can be checked for
+// drift.
+var schemaFingerprintsCache = newSchemaFingerprints()
+
+// schemaFingerprints detects a provider changing its schema mid-run: the
+// same binary should return the same GetProviderSchema response every time
+// it's instantiated within a single plan or apply, and a provider that
+// doesn't is a bug worth a clear diagnostic rather than a confusing cty
+// type error several calls later.
+type schemaFingerprints struct {
+	mu sync.Mutex
+
+	// seen maps a provider to the fingerprint of the GetProviderSchema
+	// response it returned the first time it was instantiated this run.
+	seen map[addrs.Provider][sha256.Size]byte
+
+	// instance maps a provider to an identity for whichever Interface value
+	// was last fingerprinted for it, so that repeated calls against the
+	// same already-verified instance don't pay to re-fingerprint its whole
+	// schema on every single function call.
+	instance map[addrs.Provider]any
+}
+
+func newSchemaFingerprints() *schemaFingerprints {
+	return &schemaFingerprints{
+		seen:     make(map[addrs.Provider][sha256.Size]byte),
+		instance: make(map[addrs.Provider]any),
+	}
+}
+
+// check fingerprints provider's schema and compares it against the
+// fingerprint recorded the first time provider was instantiated in this
+// run, if any, returning an error describing the drift if the two don't
+// match.
+//
+// The (comparable) instance value identifies which particular running
+// instance of provider this is, such as the Interface value BuildFunction
+// was handed by its factory. Calling check again with the same instance is
+// cheap: the schema is only re-fetched and re-hashed when instance differs
+// from the last instance checked for that provider, so alternating between
+// two instances re-fingerprints on every switch.
+func (s *schemaFingerprints) check(provider addrs.Provider, instance any, getSchema func() GetProviderSchemaResponse) error {
+	s.mu.Lock()
+	if s.instance[provider] == instance {
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+
+	fingerprint := hashProviderSchema(getSchema())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prior, ok := s.seen[provider]
+	if !ok {
+		s.seen[provider] = fingerprint
+		s.instance[provider] = instance
+		return nil
+	}
+	if prior != fingerprint {
+		return fmt.Errorf("provider schema changed mid-run: %s returned a schema that does not match the one it returned earlier in this run", provider)
+	}
+	s.instance[provider] = instance
+	return nil
+}
+
+// hashProviderSchema produces a canonical, collision-resistant fingerprint
+// of a provider's entire GetProviderSchema response, using the same
+// tag-and-length value encoding as hashValue so that two independently-
+// allocated but logically identical schemas always fingerprint the same
+// way.
+//
+// This deliberately doesn't use a schema's GoString representation: schema
+// types in this codebase hold pointer fields (for example
+// *configschema.NestedBlock), and GoString prints those pointers' addresses,
+// which differ between two otherwise-identical schemas built from separate
+// allocations.
+func hashProviderSchema(schema GetProviderSchemaResponse) [sha256.Size]byte {
+	h := sha256.New()
+	hashSchema(h, schema.Provider)
+	hashSchemas(h, schema.ResourceTypes)
+	hashSchemas(h, schema.DataSources)
+	hashFunctionDecls(h, schema.Functions)
+	return hashSum(h)
+}
+
+// hashSchemas writes a canonical encoding of a set of named resource or
+// data source schemas into h, in sorted-by-name order so the encoding
+// doesn't depend on map iteration order.
+func hashSchemas(h hash.Hash, schemas map[string]Schema) {
+	names := make([]string, 0, len(schemas))
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeUvarint(h, uint64(len(names)))
+	for _, name := range names {
+		writeString(h, name)
+		hashSchema(h, schemas[name])
+	}
+}
+
+// hashSchema writes a canonical encoding of a single resource type, data
+// source, or provider config schema into h: its version, since a version
+// bump signals a state upgrade is needed even if the block shape happens
+// not to have changed, followed by the shape of its config block.
+func hashSchema(h hash.Hash, s Schema) {
+	writeUvarint(h, uint64(s.Version))
+	hashConfigBlock(h, s.Block)
+}
+
+// hashConfigBlock writes a canonical encoding of a configschema.Block's
+// shape into h, recursing into nested blocks. It only encodes the parts of
+// a block that determine whether a configuration or state that was valid
+// against an earlier version of this schema is still valid against it now:
+// attribute types and their required/optional/computed/sensitive flags, and
+// nested block nesting modes and item-count bounds. Description text and
+// other purely cosmetic fields are intentionally left out, the same way
+// hashFunctionDecl leaves out FunctionDecl.Description.
+func hashConfigBlock(h hash.Hash, b *configschema.Block) {
+	if b == nil {
+		h.Write([]byte{0})
+		return
+	}
+	h.Write([]byte{1})
+
+	attrNames := make([]string, 0, len(b.Attributes))
+	for name := range b.Attributes {
+		attrNames = append(attrNames, name)
+	}
+	sort.Strings(attrNames)
+
+	writeUvarint(h, uint64(len(attrNames)))
+	for _, name := range attrNames {
+		writeString(h, name)
+		hashConfigAttribute(h, b.Attributes[name])
+	}
+
+	blockTypeNames := make([]string, 0, len(b.BlockTypes))
+	for name := range b.BlockTypes {
+		blockTypeNames = append(blockTypeNames, name)
+	}
+	sort.Strings(blockTypeNames)
+
+	writeUvarint(h, uint64(len(blockTypeNames)))
+	for _, name := range blockTypeNames {
+		writeString(h, name)
+		hashConfigNestedBlock(h, b.BlockTypes[name])
+	}
+}
+
+// hashConfigAttribute writes a canonical encoding of a single attribute's
+// shape into h.
+func hashConfigAttribute(h hash.Hash, a *configschema.Attribute) {
+	writeString(h, a.Type.GoString())
+	for _, flag := range []bool{a.Required, a.Optional, a.Computed, a.Sensitive} {
+		if flag {
+			h.Write([]byte{1})
+		} else {
+			h.Write([]byte{0})
+		}
+	}
+}
+
+// hashConfigNestedBlock writes a canonical encoding of a single nested
+// block's shape into h, recursing into its own block via hashConfigBlock.
+func hashConfigNestedBlock(h hash.Hash, nb *configschema.NestedBlock) {
+	writeUvarint(h, uint64(nb.Nesting))
+	writeUvarint(h, uint64(nb.MinItems))
+	writeUvarint(h, uint64(nb.MaxItems))
+	hashConfigBlock(h, &nb.Block)
+}
+
+// hashFunctionDecls writes a canonical encoding of a provider's function
+// declarations into h, in sorted-by-name order so the encoding doesn't
+// depend on map iteration order.
+func hashFunctionDecls(h hash.Hash, functions map[string]FunctionDecl) {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeUvarint(h, uint64(len(names)))
+	for _, name := range names {
+		writeString(h, name)
+		hashFunctionDecl(h, functions[name])
+	}
+}
+
+// hashFunctionDecl writes a canonical encoding of a single function
+// declaration's shape into h: its parameter and return types, since those
+// are what determine whether an argument list that type-checked against an
+// earlier call will still type-check now.
+func hashFunctionDecl(h hash.Hash, d FunctionDecl) {
+	writeUvarint(h, uint64(len(d.Parameters)))
+	for _, p := range d.Parameters {
+		hashFunctionParam(h, p)
+	}
+
+	if d.VariadicParameter != nil {
+		h.Write([]byte{1})
+		hashFunctionParam(h, *d.VariadicParameter)
+	} else {
+		h.Write([]byte{0})
+	}
+
+	writeString(h, d.ReturnType.GoString())
+}
+
+// hashFunctionParam writes a canonical encoding of a single function
+// parameter's shape into h. cty.Type.GoString produces valid Go syntax
+// describing the type's structure rather than a pointer address, so it's
+// safe to use here the same way hashValue already uses it to tag null and
+// unknown values.
+func hashFunctionParam(h hash.Hash, p FunctionParam) {
+	writeString(h, p.Type.GoString())
+	if p.AllowNullValue {
+		h.Write([]byte{1})
+	} else {
+		h.Write([]byte{0})
+	}
+	if p.AllowUnknownValues {
+		h.Write([]byte{1})
+	} else {
+		h.Write([]byte{0})
+	}
+}
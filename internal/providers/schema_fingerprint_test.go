@@ -0,0 +1,122 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package providers
+
+import (
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/configs/configschema"
+)
+
+func TestSchemaFingerprintsCheck(t *testing.T) {
+	provider := addrs.NewDefaultProvider("test")
+	s := newSchemaFingerprints()
+
+	newSchemaV1 := func() GetProviderSchemaResponse {
+		return GetProviderSchemaResponse{
+			Functions: map[string]FunctionDecl{
+				"greet": {
+					Parameters: []FunctionParam{{Name: "name", Type: cty.String}},
+					ReturnType: cty.String,
+				},
+			},
+		}
+	}
+
+	instanceA := new(int) // stands in for a distinct provider instance
+	if err := s.check(provider, instanceA, newSchemaV1); err != nil {
+		t.Fatalf("unexpected error on first instantiation: %s", err)
+	}
+
+	// A second, independently-allocated instance whose schema is logically
+	// identical (but not the same Go value) must not be reported as drift.
+	instanceB := new(int)
+	if err := s.check(provider, instanceB, newSchemaV1); err != nil {
+		t.Errorf("unexpected error for an equivalent schema from a new instance: %s", err)
+	}
+
+	// Repeat calls against an already-verified instance must not even
+	// invoke getSchema again.
+	calledAgain := false
+	poison := func() GetProviderSchemaResponse {
+		calledAgain = true
+		return newSchemaV1()
+	}
+	if err := s.check(provider, instanceB, poison); err != nil {
+		t.Errorf("unexpected error for a repeat check of the same instance: %s", err)
+	}
+	if calledAgain {
+		t.Error("check called getSchema again for an instance it had already fingerprinted")
+	}
+
+	instanceC := new(int)
+	schemaV2 := func() GetProviderSchemaResponse {
+		return GetProviderSchemaResponse{
+			Functions: map[string]FunctionDecl{
+				"greet": {
+					Parameters: []FunctionParam{{Name: "name", Type: cty.String}},
+					ReturnType: cty.Number,
+				},
+			},
+		}
+	}
+	if err := s.check(provider, instanceC, schemaV2); err == nil {
+		t.Fatal("expected an error for a changed schema, but got none")
+	}
+}
+
+// TestSchemaFingerprintsCheckResourceSchemaDrift covers the case the
+// fingerprint feature most exists for: a provider that mutates a resource
+// type's schema mid-run. Function declarations are the only thing
+// hashProviderSchema covered initially, so this guards against that
+// regressing again.
+func TestSchemaFingerprintsCheckResourceSchemaDrift(t *testing.T) {
+	provider := addrs.NewDefaultProvider("test")
+	s := newSchemaFingerprints()
+
+	schemaWithAttr := func(required bool) GetProviderSchemaResponse {
+		return GetProviderSchemaResponse{
+			Provider: Schema{
+				Block: &configschema.Block{
+					Attributes: map[string]*configschema.Attribute{
+						"region": {Type: cty.String, Required: true},
+					},
+				},
+			},
+			ResourceTypes: map[string]Schema{
+				"test_thing": {
+					Version: 1,
+					Block: &configschema.Block{
+						Attributes: map[string]*configschema.Attribute{
+							"name": {Type: cty.String, Required: required, Optional: !required},
+						},
+					},
+				},
+			},
+			DataSources: map[string]Schema{
+				"test_thing": {
+					Block: &configschema.Block{
+						Attributes: map[string]*configschema.Attribute{
+							"id": {Type: cty.String, Computed: true},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	instanceA := new(int)
+	if err := s.check(provider, instanceA, func() GetProviderSchemaResponse { return schemaWithAttr(true) }); err != nil {
+		t.Fatalf("unexpected error on first instantiation: %s", err)
+	}
+
+	instanceB := new(int)
+	err := s.check(provider, instanceB, func() GetProviderSchemaResponse { return schemaWithAttr(false) })
+	if err == nil {
+		t.Fatal("expected an error for a resource type whose attribute became optional, but got none")
+	}
+}